@@ -0,0 +1,48 @@
+package traefik_ip2location
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+// DBFormatIP2Location and DBFormatMMDB are the supported DBFormat config values.
+const (
+	DBFormatIP2Location = "ip2location"
+	DBFormatMMDB        = "mmdb"
+)
+
+// GeoDB abstracts the underlying IP geolocation database so multiple file formats can be
+// supported, and so the active database can be hot-swapped without restarting Traefik.
+type GeoDB interface {
+	LookupCountry(ip net.IP) (string, error)
+	Close() error
+}
+
+// openGeoDB opens path as a GeoDB using format, or autodetects the format from the file
+// extension when format is empty.
+func openGeoDB(path string, format string) (GeoDB, error) {
+	resolvedFormat := format
+	if resolvedFormat == "" {
+		resolvedFormat = detectDBFormat(path)
+	}
+
+	switch resolvedFormat {
+	case DBFormatMMDB:
+		return newMMDBGeoDB(path)
+	case DBFormatIP2Location:
+		return newIP2LocationGeoDB(path)
+	default:
+		return nil, fmt.Errorf("unknown DBFormat: %s", resolvedFormat)
+	}
+}
+
+// detectDBFormat guesses the database format from path's file extension, defaulting to
+// ip2location for anything that isn't recognizably an MMDB file.
+func detectDBFormat(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".mmdb") {
+		return DBFormatMMDB
+	}
+	return DBFormatIP2Location
+}
@@ -0,0 +1,36 @@
+package traefik_ip2location
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/ip2location/ip2location-go"
+)
+
+// ip2locationGeoDB adapts the ip2location-go reader to the GeoDB interface.
+type ip2locationGeoDB struct {
+	db *ip2location.DB
+}
+
+func newIP2LocationGeoDB(path string) (GeoDB, error) {
+	db, err := ip2location.OpenDB(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IP2Location database: %w", err)
+	}
+
+	return &ip2locationGeoDB{db: db}, nil
+}
+
+func (g *ip2locationGeoDB) LookupCountry(ip net.IP) (string, error) {
+	record, err := g.db.Get_all(ip.String())
+	if err != nil {
+		return "", fmt.Errorf("error getting location data: %w", err)
+	}
+
+	return record.Country_short, nil
+}
+
+func (g *ip2locationGeoDB) Close() error {
+	g.db.Close()
+	return nil
+}
@@ -0,0 +1,42 @@
+package traefik_ip2location
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// mmdbGeoDB adapts a MaxMind GeoLite2/GeoIP2 MMDB reader to the GeoDB interface.
+type mmdbGeoDB struct {
+	reader *maxminddb.Reader
+}
+
+// mmdbCountryRecord decodes just the fields we need out of a MaxMind country/city database entry.
+type mmdbCountryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+func newMMDBGeoDB(path string) (GeoDB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open MMDB database: %w", err)
+	}
+
+	return &mmdbGeoDB{reader: reader}, nil
+}
+
+func (m *mmdbGeoDB) LookupCountry(ip net.IP) (string, error) {
+	var record mmdbCountryRecord
+	if err := m.reader.Lookup(ip, &record); err != nil {
+		return "", fmt.Errorf("error getting location data: %w", err)
+	}
+
+	return record.Country.ISOCode, nil
+}
+
+func (m *mmdbGeoDB) Close() error {
+	return m.reader.Close()
+}
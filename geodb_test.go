@@ -0,0 +1,116 @@
+package traefik_ip2location
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGeoDB is a GeoDB stand-in that records whether it was closed, so tests can
+// assert hot-reload swap and drain-delay close ordering without a real database file.
+type fakeGeoDB struct {
+	mu     sync.Mutex
+	closed bool
+}
+
+func (f *fakeGeoDB) LookupCountry(ip net.IP) (string, error) {
+	return "US", nil
+}
+
+func (f *fakeGeoDB) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeGeoDB) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func TestDetectDBFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "mmdb extension", path: "/data/GeoLite2-Country.mmdb", want: DBFormatMMDB},
+		{name: "mmdb extension uppercase", path: "/data/GeoLite2-Country.MMDB", want: DBFormatMMDB},
+		{name: "bin extension defaults to ip2location", path: "/data/IP2LOCATION.BIN", want: DBFormatIP2Location},
+		{name: "no extension defaults to ip2location", path: "/data/geodb", want: DBFormatIP2Location},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectDBFormat(tt.path); got != tt.want {
+				t.Errorf("detectDBFormat(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenGeoDB_UnknownFormat(t *testing.T) {
+	if _, err := openGeoDB("/data/geodb", "exotic"); err == nil {
+		t.Fatal("expected an error for an unknown DBFormat, got nil")
+	}
+}
+
+func TestSwapDB_NewDBObservedImmediatelyAndOldDBClosedAfterDrainDelay(t *testing.T) {
+	oldDB := &fakeGeoDB{}
+	newDB := &fakeGeoDB{}
+
+	g := &GeoIP{drainDelay: 20 * time.Millisecond}
+	var old GeoDB = oldDB
+	g.db.Store(&old)
+
+	g.swapDB(newDB)
+
+	if got := *g.db.Load(); got != GeoDB(newDB) {
+		t.Fatal("expected g.db.Load() to observe the new database immediately after swapDB")
+	}
+
+	if oldDB.isClosed() {
+		t.Fatal("expected the old database to stay open before the drain delay elapses")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if !oldDB.isClosed() {
+		t.Fatal("expected the old database to be closed after the drain delay elapses")
+	}
+
+	if newDB.isClosed() {
+		t.Fatal("the new database must never be closed by swapDB")
+	}
+}
+
+func TestSwapDB_ConcurrentLookupsSeeNewDBAfterSwap(t *testing.T) {
+	oldDB := &fakeGeoDB{}
+	newDB := &fakeGeoDB{}
+
+	g := &GeoIP{drainDelay: 20 * time.Millisecond}
+	var old GeoDB = oldDB
+	g.db.Store(&old)
+
+	g.swapDB(newDB)
+
+	var wg sync.WaitGroup
+	results := make([]GeoDB, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = *g.db.Load()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, db := range results {
+		if db != GeoDB(newDB) {
+			t.Fatalf("lookup %d observed stale database after swap", i)
+		}
+	}
+}
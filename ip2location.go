@@ -5,14 +5,23 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
-
-	"github.com/ip2location/ip2location-go"
+	"sync/atomic"
+	"time"
 )
 
+// dbDrainDelay is how long a hot-reloaded database is kept open after being swapped out,
+// giving in-flight requests time to finish the lookup they already started against it.
+const dbDrainDelay = 5 * time.Second
+
 const StrategyHeader = "header"
 const StrategyPath = "path"
 const StrategyQuery = "query"
+const StrategyCookie = "cookie"
 
 // Config the plugin configuration.
 type Config struct {
@@ -24,6 +33,22 @@ type Config struct {
 	LanguageParam               string              `yaml:"languageParam"`
 	RedirectAfterHandling       bool                `yaml:"redirectAfterHandling"`
 	LanguageToCountriesOverride map[string][]string `yaml:"languageToCountriesOverride"`
+	PreferClientLanguage        bool                `yaml:"preferClientLanguage"`
+	TrustedProxies              []string            `yaml:"trustedProxies"`
+	ClientIPHeader              string              `yaml:"clientIPHeader"`
+	LanguageHosts               map[string]string   `yaml:"languageHosts"`
+	EmitAlternateLinks          bool                `yaml:"emitAlternateLinks"`
+	CookieName                  string              `yaml:"cookieName"`
+	CookieMaxAge                int                 `yaml:"cookieMaxAge"`
+	CookiePath                  string              `yaml:"cookiePath"`
+	CookieDomain                string              `yaml:"cookieDomain"`
+	CookieSecure                bool                `yaml:"cookieSecure"`
+	CookieSameSite              string              `yaml:"cookieSameSite"`
+	CookieHTTPOnly              bool                `yaml:"cookieHttpOnly"`
+	LanguageOverrideParam       string              `yaml:"languageOverrideParam"`
+	DBFormat                    string              `yaml:"dbFormat"`
+	ReloadInterval              time.Duration       `yaml:"reloadInterval"`
+	CountryLanguagePriority     map[string][]string `yaml:"countryLanguagePriority"`
 }
 
 // CreateConfig creates the default plugin configuration.
@@ -36,18 +61,43 @@ func CreateConfig() *Config {
 		LanguageParam:               "lang",
 		RedirectAfterHandling:       false,
 		LanguageToCountriesOverride: make(map[string][]string),
+		PreferClientLanguage:        false,
+		TrustedProxies:              []string{},
+		ClientIPHeader:              "",
+		LanguageHosts:               make(map[string]string),
+		EmitAlternateLinks:          false,
+		CookieName:                  "lang",
+		CookieMaxAge:                60 * 60 * 24 * 365,
+		CookiePath:                  "/",
+		CookieDomain:                "",
+		CookieSecure:                false,
+		CookieSameSite:              "lax",
+		CookieHTTPOnly:              true,
+		LanguageOverrideParam:       "setlang",
+		DBFormat:                    "",
+		ReloadInterval:              0,
+		CountryLanguagePriority:     make(map[string][]string),
 	}
 }
 
 // GeoIP a plugin.
 type GeoIP struct {
-	db                          *ip2location.DB
+	db                          atomic.Pointer[GeoDB]
 	next                        http.Handler
 	config                      *Config
 	languageToCountriesOverride map[string][]string
 	languageToCountriesDefault  map[string][]string
+	countryToLanguages          map[string][]string
+	trustedProxies              []*net.IPNet
+	trustAllProxies             bool
+	reloadStop                  chan struct{}
+	reloadDone                  chan struct{}
+	drainDelay                  time.Duration
 }
 
+// trustedProxiesAll is the literal TrustedProxies entry that trusts every proxy hop.
+const trustedProxiesAll = "all"
+
 // New creates a new plugin.
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
 	if config.DBPath == "" {
@@ -66,33 +116,91 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		return nil, fmt.Errorf("languageParam is required when LanguageStrategy is 'query'")
 	}
 
-	db, err := ip2location.OpenDB(config.DBPath)
+	for language, host := range config.LanguageHosts {
+		u, err := url.Parse(host)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("languageHosts[%s] must be an absolute URL with a scheme and host, got %q", language, host)
+		}
+	}
+
+	db, err := openGeoDB(config.DBPath, config.DBFormat)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open IP2Location database: %w", err)
+		return nil, err
 	}
 
-	return &GeoIP{
-		db:                          db,
+	trustedProxies, trustAllProxies, err := parseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse TrustedProxies: %w", err)
+	}
+
+	languageToCountriesDefault := createLanguageToCountriesMap()
+
+	g := &GeoIP{
 		next:                        next,
 		config:                      config,
 		languageToCountriesOverride: config.LanguageToCountriesOverride,
-		languageToCountriesDefault:  createLanguageToCountriesMap(),
-	}, nil
+		languageToCountriesDefault:  languageToCountriesDefault,
+		countryToLanguages:          buildCountryToLanguages(languageToCountriesDefault, config.LanguageToCountriesOverride, config.CountryLanguagePriority),
+		trustedProxies:              trustedProxies,
+		trustAllProxies:             trustAllProxies,
+		reloadStop:                  make(chan struct{}),
+		reloadDone:                  make(chan struct{}),
+		drainDelay:                  dbDrainDelay,
+	}
+	g.db.Store(&db)
+
+	if config.ReloadInterval > 0 {
+		go g.watchDB()
+	} else {
+		close(g.reloadDone)
+	}
+
+	return g, nil
 }
 
 // ServeHTTP implements the http.Handler interface.
 func (g *GeoIP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	locationData, err := g.getLocationData(r.RemoteAddr)
-	if err != nil {
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if language, ok := g.overrideLanguage(r); ok {
+		g.cookieStrategy().SetLanguage(w, r, language)
+		redirectStrippingParam(w, r, g.config.LanguageOverrideParam)
 		return
 	}
 
 	language := g.config.DefaultLanguage
 
-	if locale := normalizeLocale(locationData.Country_short); locale != "-" {
-		if languageByLocale := g.getLanguageByLocale(locale); contains(g.config.Languages, languageByLocale) {
-			language = languageByLocale
+	matchedByClient := false
+	if cookieLanguage := g.cookieLanguage(r); cookieLanguage != "" {
+		language = cookieLanguage
+		matchedByClient = true
+	} else if g.config.PreferClientLanguage {
+		if clientLanguage := parseAcceptLanguage(r.Header.Get("Accept-Language"), g.config.Languages); clientLanguage != "" {
+			language = clientLanguage
+			matchedByClient = true
+		}
+	}
+
+	if !matchedByClient {
+		country, err := g.getLocationData(g.getClientIP(r))
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if locale := normalizeLocale(country); locale != "-" {
+			if languageByLocale := g.getLanguageByLocale(locale, r.Header.Get("Accept-Language")); languageByLocale != "-" {
+				language = languageByLocale
+			}
+		}
+	}
+
+	if g.config.EmitAlternateLinks {
+		g.setAlternateLinks(w, r)
+	}
+
+	if targetHost, ok := g.config.LanguageHosts[language]; ok {
+		if u, err := url.Parse(targetHost); err == nil && u.Host != "" && u.Host != r.Host {
+			http.Redirect(w, r, g.alternateURL(r, language), http.StatusFound)
+			return
 		}
 	}
 
@@ -104,7 +212,7 @@ func (g *GeoIP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			// Maybe lang already exist
 			languageByRequest := strategy.GetLanguage(r)
 			// Set lang
-			if languageByRequest == "" || !g.isLanguage(languageByRequest) {
+			if languageByRequest != language {
 				// Executing
 				strategy.SetLanguage(w, r, language)
 				// Stop further execution if a redirect perform
@@ -119,14 +227,71 @@ func (g *GeoIP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	g.next.ServeHTTP(w, r)
 }
 
-// Close closes the IP2Location database.
+// Close stops the hot-reload watcher, if running, and closes the current GeoDB.
 func (g *GeoIP) Close() error {
-	if g.db != nil {
-		g.db.Close()
+	if g.reloadStop != nil {
+		close(g.reloadStop)
+		<-g.reloadDone
 	}
+
+	if db := g.db.Load(); db != nil {
+		return (*db).Close()
+	}
+
 	return nil
 }
 
+// watchDB periodically stats DBPath and, when its mtime advances, opens the new database and
+// atomically swaps it in, closing the previous one after a short drain delay.
+func (g *GeoIP) watchDB() {
+	defer close(g.reloadDone)
+
+	ticker := time.NewTicker(g.config.ReloadInterval)
+	defer ticker.Stop()
+
+	lastModTime := g.statDBModTime()
+
+	for {
+		select {
+		case <-g.reloadStop:
+			return
+		case <-ticker.C:
+			modTime := g.statDBModTime()
+			if modTime.IsZero() || !modTime.After(lastModTime) {
+				continue
+			}
+
+			newDB, err := openGeoDB(g.config.DBPath, g.config.DBFormat)
+			if err != nil {
+				continue
+			}
+
+			g.swapDB(newDB)
+			lastModTime = modTime
+		}
+	}
+}
+
+// swapDB atomically stores newDB as the active database and schedules the previous one to be
+// closed after drainDelay, giving in-flight lookups time to finish against it.
+func (g *GeoIP) swapDB(newDB GeoDB) {
+	oldDB := g.db.Swap(&newDB)
+
+	go func(db GeoDB) {
+		time.Sleep(g.drainDelay)
+		db.Close()
+	}(*oldDB)
+}
+
+// statDBModTime returns DBPath's modification time, or the zero Time if it can't be stat'd.
+func (g *GeoIP) statDBModTime() time.Time {
+	info, err := os.Stat(g.config.DBPath)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
 /* Handlers
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
 
@@ -138,10 +303,12 @@ type Strategy interface {
 
 type HeaderStrategy struct {
 	redirectAfterHandling bool
+	languages             []string
 }
 
 type PathStrategy struct {
 	redirectAfterHandling bool
+	languages             []string
 }
 
 type QueryStrategy struct {
@@ -149,8 +316,19 @@ type QueryStrategy struct {
 	languageParam         string
 }
 
+type CookieStrategy struct {
+	redirectAfterHandling bool
+	cookieName            string
+	maxAge                int
+	path                  string
+	domain                string
+	secure                bool
+	sameSite              http.SameSite
+	httpOnly              bool
+}
+
 func (h *HeaderStrategy) GetLanguage(r *http.Request) string {
-	return r.Header.Get("Accept-Language")
+	return parseAcceptLanguage(r.Header.Get("Accept-Language"), h.languages)
 }
 
 func (h *HeaderStrategy) SetLanguage(w http.ResponseWriter, r *http.Request, language string) {
@@ -163,13 +341,18 @@ func (h *HeaderStrategy) HasRedirectAfterHandling() bool {
 
 func (p *PathStrategy) GetLanguage(r *http.Request) string {
 	segments := strings.Split(r.URL.Path, "/")
-	if len(segments) > 1 && len(segments[1]) == 2 {
+	if len(segments) > 1 && contains(p.languages, segments[1]) {
 		return segments[1]
 	}
 	return ""
 }
 
 func (p *PathStrategy) SetLanguage(w http.ResponseWriter, r *http.Request, language string) {
+	if existing := p.GetLanguage(r); existing != "" {
+		r.URL.Path = "/" + language + strings.TrimPrefix(r.URL.Path, "/"+existing)
+		return
+	}
+
 	if r.URL.Path == "/" {
 		r.URL.Path = "/" + language
 	} else {
@@ -196,52 +379,115 @@ func (q *QueryStrategy) HasRedirectAfterHandling() bool {
 	return q.redirectAfterHandling
 }
 
+func (c *CookieStrategy) GetLanguage(r *http.Request) string {
+	cookie, err := r.Cookie(c.cookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func (c *CookieStrategy) SetLanguage(w http.ResponseWriter, r *http.Request, language string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.cookieName,
+		Value:    language,
+		MaxAge:   c.maxAge,
+		Path:     c.path,
+		Domain:   c.domain,
+		Secure:   c.secure,
+		SameSite: c.sameSite,
+		HttpOnly: c.httpOnly,
+	})
+}
+
+func (c *CookieStrategy) HasRedirectAfterHandling() bool {
+	return c.redirectAfterHandling
+}
+
 /* Helpers
  * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * * */
 
 func (g *GeoIP) getStrategy() (Strategy, error) {
 	switch g.config.LanguageStrategy {
 	case StrategyHeader:
-		return &HeaderStrategy{redirectAfterHandling: g.config.RedirectAfterHandling}, nil
+		return &HeaderStrategy{redirectAfterHandling: g.config.RedirectAfterHandling, languages: g.config.Languages}, nil
 	case StrategyPath:
-		return &PathStrategy{redirectAfterHandling: g.config.RedirectAfterHandling}, nil
+		return &PathStrategy{redirectAfterHandling: g.config.RedirectAfterHandling, languages: g.config.Languages}, nil
 	case StrategyQuery:
 		return &QueryStrategy{languageParam: g.config.LanguageParam, redirectAfterHandling: g.config.RedirectAfterHandling}, nil
+	case StrategyCookie:
+		return g.cookieStrategy(), nil
 	default:
 		return nil, fmt.Errorf("invalid LanguageStrategy: %s", g.config.LanguageStrategy)
 	}
 }
 
-func (g *GeoIP) getLocationData(remoteAddr string) (*ip2location.IP2Locationrecord, error) {
-	ip, _, err := net.SplitHostPort(remoteAddr)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing IP: %w", err)
-	}
-
+func (g *GeoIP) getLocationData(ip string) (string, error) {
 	parsedIP := net.ParseIP(ip)
 	if parsedIP == nil {
-		return nil, fmt.Errorf("invalid IP address")
+		return "", fmt.Errorf("invalid IP address")
 	}
 
-	results, err := g.db.Get_all(parsedIP.String())
+	db := g.db.Load()
+	country, err := (*db).LookupCountry(parsedIP)
 	if err != nil {
-		return nil, fmt.Errorf("error getting location data: %w", err)
+		return "", fmt.Errorf("error getting location data: %w", err)
 	}
 
-	return &results, nil
+	return country, nil
 }
 
-func (g *GeoIP) isLanguage(lang string) bool {
-	// Check the override map first
-	for language := range g.languageToCountriesOverride {
-		if language == lang {
-			return true
+// getClientIP resolves the real client address behind any trusted reverse proxies. It prefers
+// ClientIPHeader when configured (e.g. CF-Connecting-IP), otherwise walks X-Forwarded-For from
+// right to left skipping trusted hops, then falls back to X-Real-IP and finally r.RemoteAddr.
+// Forwarding headers are only honored when the direct TCP peer is itself a trusted proxy;
+// otherwise a client could simply forge them to control its own geolocated language.
+func (g *GeoIP) getClientIP(r *http.Request) string {
+	directPeer := parseAddr(r.RemoteAddr)
+	if !g.isTrustedProxy(directPeer) {
+		return directPeer
+	}
+
+	if g.config.ClientIPHeader != "" {
+		if ip := parseAddr(r.Header.Get(g.config.ClientIPHeader)); ip != "" {
+			return ip
 		}
 	}
 
-	// If not found in override, check the default map
-	for language := range g.languageToCountriesDefault {
-		if language == lang {
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := parseAddr(hops[i])
+			if ip == "" {
+				continue
+			}
+			if g.isTrustedProxy(ip) {
+				continue
+			}
+			return ip
+		}
+	}
+
+	if ip := parseAddr(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+
+	return directPeer
+}
+
+// isTrustedProxy reports whether ip falls inside the configured TrustedProxies set.
+func (g *GeoIP) isTrustedProxy(ip string) bool {
+	if g.trustAllProxies {
+		return true
+	}
+
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+
+	for _, ipNet := range g.trustedProxies {
+		if ipNet.Contains(parsedIP) {
 			return true
 		}
 	}
@@ -249,26 +495,311 @@ func (g *GeoIP) isLanguage(lang string) bool {
 	return false
 }
 
-func (g *GeoIP) getLanguageByLocale(locale string) string {
-	// Check the override map first
-	for language, countries := range g.languageToCountriesOverride {
-		for _, country := range countries {
-			if country == locale {
-				return language
+// parseAddr normalizes a host, "host:port", or bracketed IPv6 literal into a plain IP string,
+// returning "" when the value isn't a valid address.
+func parseAddr(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	} else {
+		value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
+
+// parseTrustedProxies turns the configured TrustedProxies entries (CIDRs, bare IPs, or the
+// literal "all") into a list of networks to match against. A bare IP is treated as a /32 or /128.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, bool, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		if proxy == trustedProxiesAll {
+			return nil, true, nil
+		}
+
+		if _, ipNet, err := net.ParseCIDR(proxy); err == nil {
+			nets = append(nets, ipNet)
+			continue
+		}
+
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, false, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	return nets, false, nil
+}
+
+// getLanguageByLocale resolves a country to one of config.Languages using the deterministic
+// candidate ordering precomputed into countryToLanguages. When acceptLanguage names any of the
+// supported candidates, the client's most preferred one is returned; otherwise the configured
+// (or default) priority order decides.
+func (g *GeoIP) getLanguageByLocale(locale string, acceptLanguage string) string {
+	candidates, ok := g.countryToLanguages[locale]
+	if !ok {
+		return "-"
+	}
+
+	supported := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		if contains(g.config.Languages, candidate) {
+			supported = append(supported, candidate)
+		}
+	}
+
+	if len(supported) == 0 {
+		return "-"
+	}
+
+	if preferred := parseAcceptLanguage(acceptLanguage, supported); preferred != "" {
+		return preferred
+	}
+
+	return supported[0]
+}
+
+// acceptLanguageTag is a single weighted entry parsed out of an Accept-Language header.
+type acceptLanguageTag struct {
+	lang    string
+	quality float64
+}
+
+// parseAcceptLanguage parses an Accept-Language header value (e.g. "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5"),
+// sorts the ranges by descending quality (ties keep header order), and returns the first base language
+// subtag that is present in supported. Returns "" when nothing in the header matches.
+func parseAcceptLanguage(header string, supported []string) string {
+	if header == "" {
+		return ""
+	}
+
+	var tags []acceptLanguageTag
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		lang := part
+		quality := 1.0
+
+		if idx := strings.Index(part, ";q="); idx != -1 {
+			lang = strings.TrimSpace(part[:idx])
+			if q, err := strconv.ParseFloat(strings.TrimSpace(part[idx+3:]), 64); err == nil {
+				quality = q
 			}
 		}
+
+		if quality <= 0 {
+			continue
+		}
+		if quality > 1 {
+			quality = 1
+		}
+
+		if base, _, found := strings.Cut(lang, "-"); found {
+			lang = base
+		}
+
+		tags = append(tags, acceptLanguageTag{lang: strings.ToLower(lang), quality: quality})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].quality > tags[j].quality
+	})
+
+	for _, tag := range tags {
+		if contains(supported, tag.lang) {
+			return tag.lang
+		}
+	}
+
+	return ""
+}
+
+// setAlternateLinks appends one Link: rel="alternate" header per configured language plus an
+// "x-default" entry for DefaultLanguage, so crawlers can discover the equivalent URL per locale.
+func (g *GeoIP) setAlternateLinks(w http.ResponseWriter, r *http.Request) {
+	for _, language := range g.config.Languages {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="alternate"; hreflang="%s"`, g.alternateURL(r, language), language))
+	}
+	w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="alternate"; hreflang="x-default"`, g.alternateURL(r, g.config.DefaultLanguage)))
+}
+
+// alternateURL builds the equivalent absolute URL for language, varying the path or query
+// param the same way the active LanguageStrategy would, and swapping in the language's base
+// URL from LanguageHosts when multihost mode is configured.
+func (g *GeoIP) alternateURL(r *http.Request, language string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
 	}
+	host := r.Host
+	path := r.URL.Path
+	rawQuery := r.URL.RawQuery
 
-	// If not found in override, check the default map
-	for language, countries := range g.languageToCountriesDefault {
-		for _, country := range countries {
-			if country == locale {
-				return language
+	switch g.config.LanguageStrategy {
+	case StrategyPath:
+		path = "/" + language + stripLanguagePrefix(r.URL.Path, g.config.Languages)
+	case StrategyQuery:
+		query := r.URL.Query()
+		query.Set(g.config.LanguageParam, language)
+		rawQuery = query.Encode()
+	}
+
+	if base, ok := g.config.LanguageHosts[language]; ok {
+		if u, err := url.Parse(base); err == nil && u.Host != "" {
+			scheme = u.Scheme
+			host = u.Host
+		}
+	}
+
+	alternate := url.URL{Scheme: scheme, Host: host, Path: path, RawQuery: rawQuery}
+	return alternate.String()
+}
+
+// stripLanguagePrefix removes a leading "/xx" language segment from path if xx is a configured language.
+func stripLanguagePrefix(path string, languages []string) string {
+	segments := strings.SplitN(path, "/", 3)
+	if len(segments) > 1 && contains(languages, segments[1]) {
+		if len(segments) > 2 {
+			return "/" + segments[2]
+		}
+		return "/"
+	}
+	return path
+}
+
+// cookieStrategy builds the CookieStrategy from Config, shared between the "cookie"
+// LanguageStrategy and the override/persistence precedence in ServeHTTP.
+func (g *GeoIP) cookieStrategy() *CookieStrategy {
+	return &CookieStrategy{
+		redirectAfterHandling: g.config.RedirectAfterHandling,
+		cookieName:            g.config.CookieName,
+		maxAge:                g.config.CookieMaxAge,
+		path:                  g.config.CookiePath,
+		domain:                g.config.CookieDomain,
+		secure:                g.config.CookieSecure,
+		sameSite:              parseSameSite(g.config.CookieSameSite),
+		httpOnly:              g.config.CookieHTTPOnly,
+	}
+}
+
+// overrideLanguage reports the language requested via LanguageOverrideParam (e.g. ?setlang=de),
+// if present and one of the configured Languages.
+func (g *GeoIP) overrideLanguage(r *http.Request) (string, bool) {
+	if g.config.LanguageOverrideParam == "" {
+		return "", false
+	}
+
+	value := r.URL.Query().Get(g.config.LanguageOverrideParam)
+	if value == "" || !contains(g.config.Languages, value) {
+		return "", false
+	}
+
+	return value, true
+}
+
+// cookieLanguage reads the persisted language preference cookie, honoring it only when it
+// names one of the configured Languages.
+func (g *GeoIP) cookieLanguage(r *http.Request) string {
+	cookie, err := r.Cookie(g.config.CookieName)
+	if err != nil || !contains(g.config.Languages, cookie.Value) {
+		return ""
+	}
+	return cookie.Value
+}
+
+// redirectStrippingParam redirects back to the current URL with param removed from the query string.
+func redirectStrippingParam(w http.ResponseWriter, r *http.Request, param string) {
+	query := r.URL.Query()
+	query.Del(param)
+	r.URL.RawQuery = query.Encode()
+	http.Redirect(w, r, r.URL.String(), http.StatusFound)
+}
+
+// parseSameSite maps a yaml-friendly SameSite name to its http.SameSite value, defaulting
+// to http.SameSiteDefaultMode for unrecognized values.
+func parseSameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	case "lax":
+		return http.SameSiteLaxMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
+
+// buildCountryToLanguages inverts the language->countries maps into a country->languages
+// negotiation table, so locale resolution no longer depends on Go's map iteration order.
+// Override languages are merged in before default languages (sorted by key, for
+// determinism) so they take priority. CountryLanguagePriority then reorders each country's
+// candidate list explicitly, appending any remaining candidates the operator didn't mention.
+func buildCountryToLanguages(languageToCountriesDefault, languageToCountriesOverride, countryLanguagePriority map[string][]string) map[string][]string {
+	result := make(map[string][]string)
+
+	mergeLanguages := func(languageToCountries map[string][]string) {
+		for _, language := range sortedKeys(languageToCountries) {
+			for _, country := range languageToCountries[language] {
+				if !contains(result[country], language) {
+					result[country] = append(result[country], language)
+				}
 			}
 		}
 	}
 
-	return "-"
+	mergeLanguages(languageToCountriesOverride)
+	mergeLanguages(languageToCountriesDefault)
+
+	for country, priority := range countryLanguagePriority {
+		country = normalizeLocale(country)
+		candidates := result[country]
+
+		reordered := make([]string, 0, len(candidates))
+		for _, language := range priority {
+			if contains(candidates, language) && !contains(reordered, language) {
+				reordered = append(reordered, language)
+			}
+		}
+		for _, language := range candidates {
+			if !contains(reordered, language) {
+				reordered = append(reordered, language)
+			}
+		}
+
+		result[country] = reordered
+	}
+
+	return result
+}
+
+// sortedKeys returns m's keys in ascending order, for deterministic iteration over a map.
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 func normalizeLocales(locales []string) []string {
@@ -0,0 +1,456 @@
+package traefik_ip2location
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestGeoIP(config *Config) *GeoIP {
+	return &GeoIP{
+		next:                        http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		config:                      config,
+		languageToCountriesOverride: config.LanguageToCountriesOverride,
+		languageToCountriesDefault:  createLanguageToCountriesMap(),
+	}
+}
+
+func testConfig() *Config {
+	config := CreateConfig()
+	config.Languages = []string{"en", "fr", "de"}
+	config.DefaultLanguage = "en"
+	config.LanguageStrategy = StrategyQuery
+	return config
+}
+
+func TestServeHTTP_OverrideParamPersistsCookieAndRedirects(t *testing.T) {
+	g := newTestGeoIP(testConfig())
+
+	req := httptest.NewRequest(http.MethodGet, "/?setlang=de", nil)
+	rec := httptest.NewRecorder()
+
+	g.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect status, got %d", rec.Code)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "lang" || cookies[0].Value != "de" {
+		t.Fatalf("expected lang cookie set to de, got %+v", cookies)
+	}
+
+	location := rec.Header().Get("Location")
+	if location != "/" {
+		t.Fatalf("expected redirect stripped of setlang, got %q", location)
+	}
+}
+
+func TestServeHTTP_CookieSkipsGeoIPLookup(t *testing.T) {
+	g := newTestGeoIP(testConfig())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "fr"})
+	rec := httptest.NewRecorder()
+
+	g.ServeHTTP(rec, req)
+
+	if got := req.URL.Query().Get("lang"); got != "fr" {
+		t.Fatalf("expected query strategy to set lang=fr from cookie, got %q", got)
+	}
+}
+
+func TestServeHTTP_InvalidCookieFallsThrough(t *testing.T) {
+	g := newTestGeoIP(testConfig())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "xx"})
+
+	if language := g.cookieLanguage(req); language != "" {
+		t.Fatalf("expected unsupported cookie language to be ignored, got %q", language)
+	}
+}
+
+func TestOverrideLanguage(t *testing.T) {
+	g := newTestGeoIP(testConfig())
+
+	tests := []struct {
+		name     string
+		url      string
+		wantLang string
+		wantOK   bool
+	}{
+		{"valid override", "/?setlang=de", "de", true},
+		{"unsupported override", "/?setlang=xx", "", false},
+		{"missing override", "/", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			language, ok := g.overrideLanguage(req)
+			if language != tt.wantLang || ok != tt.wantOK {
+				t.Fatalf("overrideLanguage(%q) = (%q, %v), want (%q, %v)", tt.url, language, ok, tt.wantLang, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetLanguageByLocale_DeterministicPriorityOverride(t *testing.T) {
+	config := testConfig()
+	config.Languages = []string{"en", "fr", "de", "it"}
+	config.CountryLanguagePriority = map[string][]string{
+		"CH": {"de", "fr", "it"},
+	}
+
+	g := newTestGeoIP(config)
+	g.countryToLanguages = buildCountryToLanguages(g.languageToCountriesDefault, g.languageToCountriesOverride, config.CountryLanguagePriority)
+
+	for i := 0; i < 10; i++ {
+		if got := g.getLanguageByLocale("CH", ""); got != "de" {
+			t.Fatalf("expected CH to resolve to de per CountryLanguagePriority, got %q", got)
+		}
+	}
+}
+
+func TestGetLanguageByLocale_ClientPreferenceBreaksTie(t *testing.T) {
+	config := testConfig()
+	config.Languages = []string{"en", "fr", "de", "it"}
+	config.CountryLanguagePriority = map[string][]string{
+		"CH": {"de", "fr", "it"},
+	}
+
+	g := newTestGeoIP(config)
+	g.countryToLanguages = buildCountryToLanguages(g.languageToCountriesDefault, g.languageToCountriesOverride, config.CountryLanguagePriority)
+
+	if got := g.getLanguageByLocale("CH", "fr;q=0.9, it;q=0.8"); got != "fr" {
+		t.Fatalf("expected client Accept-Language to break the tie among CH's candidates, got %q", got)
+	}
+}
+
+func TestGetLanguageByLocale_UnknownCountry(t *testing.T) {
+	g := newTestGeoIP(testConfig())
+	g.countryToLanguages = buildCountryToLanguages(g.languageToCountriesDefault, g.languageToCountriesOverride, nil)
+
+	if got := g.getLanguageByLocale("ZZ", ""); got != "-" {
+		t.Fatalf("expected unknown country to resolve to \"-\", got %q", got)
+	}
+}
+
+func TestServeHTTP_StaleCookieOutsideConfiguredLanguagesGetsCorrected(t *testing.T) {
+	config := testConfig()
+	config.LanguageStrategy = StrategyCookie
+	config.PreferClientLanguage = true
+	config.DefaultLanguageHandling = true
+
+	g := newTestGeoIP(config)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	// Resolved via Accept-Language so the test never needs a real GeoDB.
+	req.Header.Set("Accept-Language", config.DefaultLanguage)
+	// "ja" is a recognized built-in language, but isn't in config.Languages (en/fr/de) -
+	// the "already set" check must still overwrite it rather than trusting it forever.
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "ja"})
+	rec := httptest.NewRecorder()
+
+	g.ServeHTTP(rec, req)
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "lang" || cookies[0].Value != config.DefaultLanguage {
+		t.Fatalf("expected stale unsupported cookie language to be corrected to %q, got %+v", config.DefaultLanguage, cookies)
+	}
+}
+
+func TestServeHTTP_CookieOverridesExistingPathSegment(t *testing.T) {
+	config := testConfig()
+	config.LanguageStrategy = StrategyPath
+
+	g := newTestGeoIP(config)
+
+	req := httptest.NewRequest(http.MethodGet, "/fr/products", nil)
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "de"})
+	rec := httptest.NewRecorder()
+
+	g.ServeHTTP(rec, req)
+
+	if req.URL.Path != "/de/products" {
+		t.Fatalf("expected cookie language to replace existing path segment, got %q", req.URL.Path)
+	}
+}
+
+func TestServeHTTP_PathStrategyPreservesNonLanguageFirstSegment(t *testing.T) {
+	config := testConfig()
+	config.LanguageStrategy = StrategyPath
+
+	g := newTestGeoIP(config)
+
+	req := httptest.NewRequest(http.MethodGet, "/us/dashboard", nil)
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "de"})
+	rec := httptest.NewRecorder()
+
+	g.ServeHTTP(rec, req)
+
+	if req.URL.Path != "/de/us/dashboard" {
+		t.Fatalf("expected language prefix prepended without dropping non-language segment, got %q", req.URL.Path)
+	}
+}
+
+func TestServeHTTP_CookieOverridesExistingQueryParam(t *testing.T) {
+	config := testConfig()
+
+	g := newTestGeoIP(config)
+
+	req := httptest.NewRequest(http.MethodGet, "/?lang=fr", nil)
+	req.AddCookie(&http.Cookie{Name: "lang", Value: "de"})
+	rec := httptest.NewRecorder()
+
+	g.ServeHTTP(rec, req)
+
+	if got := req.URL.Query().Get("lang"); got != "de" {
+		t.Fatalf("expected cookie language to replace existing query param, got %q", got)
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	supported := []string{"en", "fr", "de"}
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"multi-range picks highest quality", "de;q=0.7, fr;q=0.9, en;q=0.8", "fr"},
+		{"q=0 excludes the range", "fr;q=0, en;q=0.5", "en"},
+		{"quality above 1 is clamped and still ranks first", "fr;q=2, en;q=0.5", "fr"},
+		{"equal quality keeps header order", "de;q=0.8, en;q=0.8, fr;q=0.8", "de"},
+		{"region subtag falls back to base language", "fr-CH, en;q=0.5", "fr"},
+		{"unsupported languages are skipped", "ja, ko;q=0.9, fr;q=0.5", "fr"},
+		{"empty header matches nothing", "", ""},
+		{"no overlap with supported languages", "ja, ko", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAcceptLanguage(tt.header, supported); got != tt.want {
+				t.Fatalf("parseAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"bare IPv4", "203.0.113.66", "203.0.113.66"},
+		{"IPv4 with port", "203.0.113.66:12345", "203.0.113.66"},
+		{"bare IPv6", "2001:db8::1", "2001:db8::1"},
+		{"bracketed IPv6 with port", "[2001:db8::1]:12345", "2001:db8::1"},
+		{"bracketed IPv6 without port", "[2001:db8::1]", "2001:db8::1"},
+		{"empty", "", ""},
+		{"garbage", "not-an-ip", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAddr(tt.value); got != tt.want {
+				t.Fatalf("parseAddr(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, all, err := parseTrustedProxies([]string{"10.0.0.0/8", "203.0.113.5", "::1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if all {
+		t.Fatalf("expected trustAll to be false")
+	}
+	if len(nets) != 3 {
+		t.Fatalf("expected 3 networks, got %d", len(nets))
+	}
+
+	if _, trustAll, err := parseTrustedProxies([]string{"all"}); err != nil || !trustAll {
+		t.Fatalf("expected \"all\" to set trustAll, got trustAll=%v err=%v", trustAll, err)
+	}
+
+	if _, _, err := parseTrustedProxies([]string{"not-an-ip"}); err == nil {
+		t.Fatalf("expected error for invalid trusted proxy")
+	}
+}
+
+func TestIsTrustedProxy(t *testing.T) {
+	config := testConfig()
+	g := newTestGeoIP(config)
+
+	nets, trustAll, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g.trustedProxies = nets
+	g.trustAllProxies = trustAll
+
+	if !g.isTrustedProxy("10.1.2.3") {
+		t.Fatalf("expected 10.1.2.3 to be trusted")
+	}
+	if g.isTrustedProxy("203.0.113.66") {
+		t.Fatalf("expected 203.0.113.66 to be untrusted")
+	}
+	if g.isTrustedProxy("") {
+		t.Fatalf("expected empty/invalid address to be untrusted")
+	}
+}
+
+func TestGetClientIP_UntrustedDirectPeerIgnoresForwardingHeaders(t *testing.T) {
+	config := testConfig()
+	g := newTestGeoIP(config)
+
+	nets, trustAll, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g.trustedProxies = nets
+	g.trustAllProxies = trustAll
+
+	// The direct peer (203.0.113.66) is NOT in TrustedProxies, so a forged X-Forwarded-For
+	// naming a trusted-looking hop must be ignored entirely - the connecting peer wins.
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.66:12345"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9, 10.0.0.5")
+
+	if got := g.getClientIP(req); got != "203.0.113.66" {
+		t.Fatalf("expected untrusted direct peer to win over forged XFF, got %q", got)
+	}
+}
+
+func TestGetClientIP_TrustedDirectPeerWalksForwardedFor(t *testing.T) {
+	config := testConfig()
+	g := newTestGeoIP(config)
+
+	nets, trustAll, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g.trustedProxies = nets
+	g.trustAllProxies = trustAll
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.5:12345"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9, 10.0.0.6")
+
+	if got := g.getClientIP(req); got != "198.51.100.9" {
+		t.Fatalf("expected first untrusted XFF hop from a trusted peer, got %q", got)
+	}
+}
+
+func TestGetClientIP_ClientIPHeaderRequiresTrustedDirectPeer(t *testing.T) {
+	config := testConfig()
+	config.ClientIPHeader = "CF-Connecting-IP"
+	g := newTestGeoIP(config)
+
+	nets, trustAll, err := parseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	g.trustedProxies = nets
+	g.trustAllProxies = trustAll
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.66:12345"
+	req.Header.Set("CF-Connecting-IP", "198.51.100.9")
+
+	if got := g.getClientIP(req); got != "203.0.113.66" {
+		t.Fatalf("expected ClientIPHeader to be ignored from an untrusted peer, got %q", got)
+	}
+}
+
+func TestGetClientIP_FallsBackToRemoteAddr(t *testing.T) {
+	config := testConfig()
+	g := newTestGeoIP(config)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.66:12345"
+
+	if got := g.getClientIP(req); got != "203.0.113.66" {
+		t.Fatalf("expected RemoteAddr fallback, got %q", got)
+	}
+}
+
+func TestNew_RejectsInvalidLanguageHosts(t *testing.T) {
+	config := testConfig()
+	config.DBPath = "testdata.bin"
+	config.LanguageHosts = map[string]string{"fr": "example.fr"}
+
+	if _, err := New(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), config, "test"); err == nil {
+		t.Fatalf("expected New to reject a LanguageHosts entry missing a scheme")
+	}
+}
+
+func TestAlternateURL(t *testing.T) {
+	config := testConfig()
+	config.LanguageStrategy = StrategyPath
+	config.LanguageHosts = map[string]string{
+		"fr": "https://example.fr",
+	}
+	g := newTestGeoIP(config)
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/en/products?x=1", nil)
+
+	if got := g.alternateURL(req, "de"); got != "https://example.com/de/products?x=1" {
+		t.Fatalf("expected path-stripped alternate URL, got %q", got)
+	}
+
+	if got := g.alternateURL(req, "fr"); got != "https://example.fr/fr/products?x=1" {
+		t.Fatalf("expected LanguageHosts to swap the scheme/host, got %q", got)
+	}
+}
+
+func TestServeHTTP_CrossHostRedirectForLanguageHosts(t *testing.T) {
+	config := testConfig()
+	config.LanguageStrategy = StrategyPath
+	config.PreferClientLanguage = true
+	config.LanguageHosts = map[string]string{
+		"fr": "https://example.fr",
+	}
+	g := newTestGeoIP(config)
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/products", nil)
+	req.Host = "example.com"
+	req.Header.Set("Accept-Language", "fr")
+	rec := httptest.NewRecorder()
+
+	g.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected cross-host redirect, got status %d", rec.Code)
+	}
+	if got := rec.Header().Get("Location"); got != "https://example.fr/fr/products" {
+		t.Fatalf("expected redirect to the fr host, got %q", got)
+	}
+}
+
+func TestServeHTTP_NoCrossHostRedirectWhenHostMatches(t *testing.T) {
+	config := testConfig()
+	config.LanguageStrategy = StrategyPath
+	config.PreferClientLanguage = true
+	config.LanguageHosts = map[string]string{
+		"en": "https://example.com",
+	}
+	g := newTestGeoIP(config)
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/products", nil)
+	req.Host = "example.com"
+	req.Header.Set("Accept-Language", "en")
+	rec := httptest.NewRecorder()
+
+	g.ServeHTTP(rec, req)
+
+	if rec.Code == http.StatusFound {
+		t.Fatalf("expected no redirect when the resolved language's host matches the current host")
+	}
+}